@@ -0,0 +1,210 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+)
+
+// bloomTargetFalsePositiveRate is the false-positive rate that the prefilter
+// is sized for. A "may contain" answer still falls through to the slower,
+// authoritative database lookup; only a "definitely not present" answer is
+// trusted outright, so this only trades a small amount of avoidable
+// database work for a much larger amount of avoided work.
+const bloomTargetFalsePositiveRate = 0.01
+
+// bloomFilter is a Bloom filter over the set of partial hashes known to the
+// local database. It lets LookupURLsContext skip the database's prefix
+// tables entirely for the overwhelmingly common "definitely safe" case.
+//
+// The database stores entries as prefixes of the full 32-byte hash,
+// truncated to whatever length that particular list uses (commonly, but
+// not always, 4 bytes). Membership must therefore be tested the same way
+// wr.db.Lookup tests it: by truncating the full hash being queried to each
+// length the database actually stores before probing, never by hashing the
+// full, untruncated digest against bits that were only ever set from short
+// prefixes.
+//
+// bloomFilter is immutable once built; UpdateClient swaps in a freshly
+// built filter under an atomic.Value rather than mutating one in place.
+type bloomFilter struct {
+	bits []uint64
+	m    uint64
+	k    int
+
+	// lengths holds every distinct prefix byte-length present in the set
+	// the filter was built from, sorted ascending. mayContain probes the
+	// query hash truncated to each of these lengths in turn.
+	lengths []int
+}
+
+// newBloomFilter builds an empty filter sized for n elements at
+// bloomTargetFalsePositiveRate, that will probe queries truncated to each
+// of lengths.
+func newBloomFilter(n int, lengths []int) *bloomFilter {
+	if n <= 0 {
+		n = 1
+	}
+	m := bloomSize(n, bloomTargetFalsePositiveRate)
+	k := bloomHashCount(m, uint64(n))
+
+	sorted := append([]int(nil), lengths...)
+	sort.Ints(sorted)
+
+	return &bloomFilter{
+		bits:    make([]uint64, (m+63)/64),
+		m:       m,
+		k:       k,
+		lengths: sorted,
+	}
+}
+
+// bloomSize returns the number of bits m needed to hold n elements at the
+// target false-positive rate p, using the standard Bloom filter sizing
+// formula m = ceil(-n * ln(p) / ln(2)^2).
+func bloomSize(n int, p float64) uint64 {
+	m := math.Ceil(-1 * float64(n) * math.Log(p) / (math.Ln2 * math.Ln2))
+	if m < 64 {
+		m = 64
+	}
+	return uint64(m)
+}
+
+// bloomHashCount returns the number of hash functions k = round((m/n) * ln2)
+// that minimizes the false-positive rate for a filter of m bits holding n
+// elements.
+func bloomHashCount(m, n uint64) int {
+	k := int(math.Round((float64(m) / float64(n)) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return k
+}
+
+// bloomIndexes derives k bit indexes for h using Kirsch-Mitzenmacher double
+// hashing: the SHA-256 digest of h is split into four 64-bit words, which
+// are folded into two seeds h1 and h2, and then h_i = h1 + i*h2 for i in
+// [0, k).
+func bloomIndexes(h hashPrefix, m uint64, k int) []uint64 {
+	sum := sha256.Sum256([]byte(h))
+	w0 := binary.BigEndian.Uint64(sum[0:8])
+	w1 := binary.BigEndian.Uint64(sum[8:16])
+	w2 := binary.BigEndian.Uint64(sum[16:24])
+	w3 := binary.BigEndian.Uint64(sum[24:32])
+	h1, h2 := w0^w2, w1^w3
+
+	idxs := make([]uint64, k)
+	for i := 0; i < k; i++ {
+		idxs[i] = (h1 + uint64(i)*h2) % m
+	}
+	return idxs
+}
+
+// add records h's membership in the filter. h must be exactly as long as
+// it is stored in the database (the database's native prefix length for
+// its list), matching what mayContain truncates queries to.
+func (f *bloomFilter) add(h hashPrefix) {
+	for _, idx := range bloomIndexes(h, f.m, f.k) {
+		f.bits[idx/64] |= 1 << (idx % 64)
+	}
+}
+
+// probe reports whether h, exactly as given, might be a member of the
+// filter. A false result is authoritative: h is definitely not a member.
+func (f *bloomFilter) probe(h hashPrefix) bool {
+	for _, idx := range bloomIndexes(h, f.m, f.k) {
+		if f.bits[idx/64]&(1<<(idx%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// mayContain reports whether full, a full 32-byte hash, might match an
+// entry in the database. Since the database stores prefixes, not full
+// hashes, this truncates full to every prefix length the filter was built
+// from and probes each in turn, exactly mirroring how wr.db.Lookup itself
+// matches a full hash against its prefix tables. A false result means full
+// cannot match at any of those lengths, i.e. it is definitely not present.
+func (f *bloomFilter) mayContain(full hashPrefix) bool {
+	for _, l := range f.lengths {
+		if l > len(full) {
+			continue
+		}
+		if f.probe(full[:l]) {
+			return true
+		}
+	}
+	return false
+}
+
+// marshalBinary serializes f so it can be persisted alongside the database
+// file and reloaded on the next cold start.
+func (f *bloomFilter) marshalBinary() []byte {
+	buf := make([]byte, 24+8*len(f.bits)+8*len(f.lengths))
+	binary.BigEndian.PutUint64(buf[0:8], f.m)
+	binary.BigEndian.PutUint64(buf[8:16], uint64(f.k))
+	binary.BigEndian.PutUint64(buf[16:24], uint64(len(f.lengths)))
+	off := 24
+	for _, l := range f.lengths {
+		binary.BigEndian.PutUint64(buf[off:], uint64(l))
+		off += 8
+	}
+	for _, word := range f.bits {
+		binary.BigEndian.PutUint64(buf[off:], word)
+		off += 8
+	}
+	return buf
+}
+
+// unmarshalBloomFilter is the inverse of marshalBinary.
+func unmarshalBloomFilter(buf []byte) (*bloomFilter, error) {
+	if len(buf) < 24 {
+		return nil, errors.New("webrisk: corrupt bloom filter snapshot")
+	}
+	f := &bloomFilter{
+		m: binary.BigEndian.Uint64(buf[0:8]),
+		k: int(binary.BigEndian.Uint64(buf[8:16])),
+	}
+	numLengths := binary.BigEndian.Uint64(buf[16:24])
+	off := 24
+	if uint64(len(buf)-off) < numLengths*8 {
+		return nil, errors.New("webrisk: corrupt bloom filter snapshot")
+	}
+	f.lengths = make([]int, numLengths)
+	for i := range f.lengths {
+		f.lengths[i] = int(binary.BigEndian.Uint64(buf[off:]))
+		off += 8
+	}
+	if (len(buf)-off)%8 != 0 {
+		return nil, errors.New("webrisk: corrupt bloom filter snapshot")
+	}
+	f.bits = make([]uint64, (len(buf)-off)/8)
+	for i := range f.bits {
+		f.bits[i] = binary.BigEndian.Uint64(buf[off:])
+		off += 8
+	}
+	// A short write (e.g. a crash mid-persist) can leave buf a clean
+	// multiple of 8 bytes while still holding fewer words than m bits
+	// require; probe/add would then index past f.bits. Catch that here
+	// rather than panicking on the first out-of-range bit index.
+	if uint64(len(f.bits)) != (f.m+63)/64 {
+		return nil, errors.New("webrisk: corrupt bloom filter snapshot")
+	}
+	return f, nil
+}
@@ -0,0 +1,92 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import "testing"
+
+func TestBloomSize(t *testing.T) {
+	m := bloomSize(1000, 0.01)
+	// For n=1000 and p=0.01, the standard formula gives m ≈ 9586 bits.
+	if m < 9000 || m > 10200 {
+		t.Errorf("bloomSize(1000, 0.01) = %d, want roughly 9586", m)
+	}
+	if got := bloomSize(0, 0.01); got < 64 {
+		t.Errorf("bloomSize(0, 0.01) = %d, want floor of 64", got)
+	}
+}
+
+func TestBloomHashCount(t *testing.T) {
+	if k := bloomHashCount(9586, 1000); k < 6 || k > 7 {
+		t.Errorf("bloomHashCount(9586, 1000) = %d, want 6 or 7", k)
+	}
+	if k := bloomHashCount(10, 1000); k < 1 {
+		t.Errorf("bloomHashCount must never return less than 1 hash function, got %d", k)
+	}
+}
+
+func fullTestHash() hashPrefix {
+	b := make([]byte, 32)
+	for i := range b {
+		b[i] = byte(i + 1)
+	}
+	return hashPrefix(b)
+}
+
+// TestBloomFilterAddContainsTruncated guards against the full-hash vs.
+// partial-hash length mismatch: the filter is built over short database
+// prefixes, so mayContain on a full 32-byte hash must truncate to those
+// same prefix lengths before probing, not hash the untruncated digest.
+func TestBloomFilterAddContainsTruncated(t *testing.T) {
+	full := fullTestHash()
+	prefix4 := full[:4]
+
+	f := newBloomFilter(10, []int{4})
+	f.add(prefix4)
+
+	if !f.mayContain(full) {
+		t.Fatalf("mayContain(%x) = false after add(%x); want true since full shares the stored 4-byte prefix", []byte(full), []byte(prefix4))
+	}
+}
+
+func TestBloomFilterMayContainRejectsUnrelatedHash(t *testing.T) {
+	stored := hashPrefix([]byte{0x01, 0x02, 0x03, 0x04})
+	otherBytes := make([]byte, 32)
+	for i := range otherBytes {
+		otherBytes[i] = 0xFF
+	}
+	other := hashPrefix(otherBytes)
+
+	f := newBloomFilter(1, []int{4})
+	f.add(stored)
+
+	if f.mayContain(other) {
+		t.Fatalf("mayContain matched an unrelated hash; filter should only ever probe the lengths it was built from")
+	}
+}
+
+func TestBloomFilterMarshalRoundTrip(t *testing.T) {
+	full := fullTestHash()
+
+	f := newBloomFilter(5, []int{4, 32})
+	f.add(full[:4])
+	f.add(full)
+
+	f2, err := unmarshalBloomFilter(f.marshalBinary())
+	if err != nil {
+		t.Fatalf("unmarshalBloomFilter: %v", err)
+	}
+	if !f2.mayContain(full) {
+		t.Fatalf("round-tripped filter lost membership of a hash present before serialization")
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"context"
+	"time"
+
+	pb "github.com/google/webrisk/internal/webrisk_proto"
+)
+
+// CacheResult is the outcome of a Cache.Lookup call.
+type CacheResult = cacheResult
+
+// Cache is the interface satisfied by a full-hash cache used to avoid
+// repeated Web Risk API calls for recently seen URLs. It mirrors the
+// package's built-in in-process cache, and is exported so it can be
+// replaced with a shared backend (see NewRedisCache) when a fleet of
+// UpdateClient instances sits behind a load balancer.
+//
+// Every method takes a context so a backend that talks to the network
+// (e.g. RedisCache) honors the caller's deadline instead of blocking
+// indefinitely; Config.RequestTimeout already bounds the ctx passed in by
+// LookupURLsContext and the background updater. Implementations must be
+// safe for concurrent use, since LookupURLsContext may call Lookup and
+// Update from multiple goroutines for a single request.
+type Cache interface {
+	// Lookup reports whether hash is cached, and if so, which ThreatTypes
+	// it is cached against. A CacheResult of negativeCacheHit means hash
+	// is cached as definitely safe; positiveCacheHit means the returned
+	// map holds its cached threats; anything else means the cache has no
+	// opinion and the caller must ask the API.
+	Lookup(ctx context.Context, hash hashPrefix) (map[ThreatType]struct{}, CacheResult)
+
+	// Update records the outcome of a SearchHashes request against the
+	// cache. now is used to compute entry expirations instead of
+	// time.Now so that backends can be driven deterministically in tests.
+	Update(ctx context.Context, req *pb.SearchHashesRequest, resp *pb.SearchHashesResponse, now time.Time) error
+
+	// Purge invalidates every cached entry.
+	Purge(ctx context.Context) error
+}
@@ -0,0 +1,168 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	pb "github.com/google/webrisk/internal/webrisk_proto"
+)
+
+// RedisCache is a Cache backed by a shared Redis instance, so that a fleet
+// of UpdateClient instances behind a load balancer share one view of which
+// full hashes are known-safe or known-unsafe instead of each independently
+// re-querying the Web Risk API and wasting QPS quota.
+//
+// Entries are namespaced by a version counter stored alongside them in
+// Redis. Purge bumps that counter rather than issuing a FLUSHDB, so it
+// invalidates every entry in O(1) without disturbing entries another
+// replica is concurrently writing under the new namespace.
+type RedisCache struct {
+	rdb      *redis.Client
+	keyBase  string
+	fixedTTL time.Duration
+}
+
+// NewRedisCache returns a Cache that stores entries in rdb under keys
+// prefixed with keyBase. If fixedTTL is non-zero, it overrides the TTL
+// that would otherwise be derived from a response's ExpireTime or
+// NegativeExpireTime, mirroring Config.FixedCacheTTL.
+func NewRedisCache(rdb *redis.Client, keyBase string, fixedTTL time.Duration) *RedisCache {
+	return &RedisCache{rdb: rdb, keyBase: keyBase, fixedTTL: fixedTTL}
+}
+
+// redisCacheEntry is the JSON envelope stored per full hash. An empty
+// Threats slice represents a confirmed-negative (definitely safe) entry.
+type redisCacheEntry struct {
+	Threats []pb.ThreatType `json:"threats"`
+}
+
+func (rc *RedisCache) versionKey() string {
+	return rc.keyBase + ":version"
+}
+
+func (rc *RedisCache) namespace(ctx context.Context) (int64, error) {
+	v, err := rc.rdb.Get(ctx, rc.versionKey()).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return v, err
+}
+
+func (rc *RedisCache) entryKey(ns int64, hash hashPrefix) string {
+	return fmt.Sprintf("%s:%d:%x", rc.keyBase, ns, string(hash))
+}
+
+// ttlFor picks the TTL for an entry expiring at expire, observed at now.
+// rc.fixedTTL takes priority when set; otherwise the time remaining until
+// expire is used, or one minute when expire is zero or already past, so
+// such an entry is never stored without a TTL.
+func (rc *RedisCache) ttlFor(expire, now time.Time) time.Duration {
+	if rc.fixedTTL > 0 {
+		return rc.fixedTTL
+	}
+	if d := expire.Sub(now); d > 0 {
+		return d
+	}
+	return time.Minute
+}
+
+// Lookup implements Cache.
+func (rc *RedisCache) Lookup(ctx context.Context, hash hashPrefix) (map[ThreatType]struct{}, CacheResult) {
+	ns, err := rc.namespace(ctx)
+	if err != nil {
+		return nil, unsureCacheHit
+	}
+
+	b, err := rc.rdb.Get(ctx, rc.entryKey(ns, hash)).Bytes()
+	if err != nil {
+		// redis.Nil (no entry) and any backend error both fall through to
+		// the API; a cache is an optimization, not a source of truth.
+		return nil, unsureCacheHit
+	}
+
+	var entry redisCacheEntry
+	if err := json.Unmarshal(b, &entry); err != nil {
+		return nil, unsureCacheHit
+	}
+	if len(entry.Threats) == 0 {
+		return nil, negativeCacheHit
+	}
+
+	threats := make(map[ThreatType]struct{}, len(entry.Threats))
+	for _, tt := range entry.Threats {
+		threats[ThreatType(tt)] = struct{}{}
+	}
+	return threats, positiveCacheHit
+}
+
+// Update implements Cache. It stores one entry per full hash: a positive
+// entry for each hash the API returned a threat for, and a negative entry
+// for every other full hash generated from req.Url, so that a subsequent
+// Lookup for the same URL is satisfied entirely from the cache.
+func (rc *RedisCache) Update(ctx context.Context, req *pb.SearchHashesRequest, resp *pb.SearchHashesResponse, now time.Time) error {
+	ns, err := rc.namespace(ctx)
+	if err != nil {
+		return err
+	}
+
+	byHash := make(map[hashPrefix][]pb.ThreatType)
+	expireAt := make(map[hashPrefix]time.Time)
+	for _, t := range resp.GetThreats() {
+		h := hashPrefix(t.Hash)
+		byHash[h] = append(byHash[h], t.ThreatTypes...)
+		if t.ExpireTime != nil {
+			expireAt[h] = t.ExpireTime.AsTime()
+		}
+	}
+	for hash, tts := range byHash {
+		b, err := json.Marshal(redisCacheEntry{Threats: tts})
+		if err != nil {
+			continue
+		}
+		rc.rdb.Set(ctx, rc.entryKey(ns, hash), b, rc.ttlFor(expireAt[hash], now))
+	}
+
+	var negExpire time.Time
+	if resp.NegativeExpireTime != nil {
+		negExpire = resp.NegativeExpireTime.AsTime()
+	}
+	negTTL := rc.ttlFor(negExpire, now)
+
+	urlhashes, err := generateHashes(req.Url)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(redisCacheEntry{})
+	if err != nil {
+		return err
+	}
+	for h := range urlhashes {
+		if _, ok := byHash[h]; ok {
+			continue
+		}
+		rc.rdb.Set(ctx, rc.entryKey(ns, h), b, negTTL)
+	}
+	return nil
+}
+
+// Purge implements Cache by bumping the namespace version.
+func (rc *RedisCache) Purge(ctx context.Context) error {
+	return rc.rdb.Incr(ctx, rc.versionKey()).Err()
+}
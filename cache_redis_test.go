@@ -0,0 +1,58 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRedisCacheEntryKeyNamespaced(t *testing.T) {
+	rc := &RedisCache{keyBase: "webrisk"}
+	a := rc.entryKey(1, hashPrefix("aaaa"))
+	b := rc.entryKey(2, hashPrefix("aaaa"))
+	if a == b {
+		t.Errorf("entryKey(1, ...) == entryKey(2, ...) = %q, want distinct keys per namespace so Purge's version bump actually invalidates", a)
+	}
+	if got, want := rc.entryKey(1, hashPrefix("aaaa")), a; got != want {
+		t.Errorf("entryKey is not deterministic: %q != %q", got, want)
+	}
+}
+
+func TestRedisCacheTTLForFixedOverride(t *testing.T) {
+	rc := &RedisCache{fixedTTL: time.Minute}
+	now := time.Unix(1000, 0)
+	expire := now.Add(time.Hour)
+	if got := rc.ttlFor(expire, now); got != time.Minute {
+		t.Errorf("ttlFor = %v, want fixedTTL (%v) to take priority over the computed expiry", got, time.Minute)
+	}
+}
+
+func TestRedisCacheTTLForComputed(t *testing.T) {
+	rc := &RedisCache{}
+	now := time.Unix(1000, 0)
+	expire := now.Add(30 * time.Second)
+	if got := rc.ttlFor(expire, now); got != 30*time.Second {
+		t.Errorf("ttlFor = %v, want 30s remaining until expire", got)
+	}
+}
+
+func TestRedisCacheTTLForFloorsPastExpiry(t *testing.T) {
+	rc := &RedisCache{}
+	now := time.Unix(1000, 0)
+	expire := now.Add(-time.Minute)
+	if got := rc.ttlFor(expire, now); got != time.Minute {
+		t.Errorf("ttlFor = %v, want the 1-minute floor for an already-expired entry", got)
+	}
+}
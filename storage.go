@@ -0,0 +1,49 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"context"
+	"time"
+)
+
+// Storage is the interface that a database uses to persist its synced
+// threat list snapshot and to discover when another writer has produced a
+// newer one. Implementations must be safe for concurrent use.
+//
+// A single snapshot is an opaque, already-serialized blob produced by the
+// database package; Storage implementations must not interpret its
+// contents.
+type Storage interface {
+	// Load returns the most recently saved snapshot along with the time it
+	// was saved. It returns errStorageNotFound if no snapshot has been
+	// saved yet.
+	Load(ctx context.Context) (snapshot []byte, updatedAt time.Time, err error)
+
+	// Save persists snapshot, recording updatedAt as the time it was
+	// produced. Save overwrites any previously saved snapshot.
+	Save(ctx context.Context, snapshot []byte, updatedAt time.Time) error
+
+	// Watch returns a channel on which a value is sent every time a Save
+	// call (from this process or another one sharing the same backing
+	// store) completes. The channel is closed once ctx is done. A backend
+	// that cannot observe saves, such as FileStorage, never sends on it
+	// and only closes it.
+	//
+	// Watch is used to let a fleet of UpdateClient instances share one
+	// authoritative list: only one replica needs to actually call the
+	// Web Risk API, and the rest pick up the refreshed snapshot through
+	// their Storage's Watch channel.
+	Watch(ctx context.Context) <-chan struct{}
+}
@@ -0,0 +1,98 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// EtcdStorage is a Storage backend that persists the threat list snapshot
+// to a single key in an etcd v3 cluster. It lets a fleet of UpdateClient
+// instances share one authoritative list and one update lag, with etcd's
+// watch API used to propagate refreshes.
+type EtcdStorage struct {
+	cli *clientv3.Client
+	key string
+}
+
+// NewEtcdStorage returns a Storage backend that persists snapshots under
+// key using cli. The caller retains ownership of cli and must Close it.
+func NewEtcdStorage(cli *clientv3.Client, key string) *EtcdStorage {
+	return &EtcdStorage{cli: cli, key: key}
+}
+
+// etcdSnapshot is the JSON envelope stored in the etcd value, since etcd
+// values are opaque byte strings and we need to carry updatedAt alongside
+// the snapshot bytes.
+type etcdSnapshot struct {
+	Snapshot  []byte    `json:"snapshot"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Load implements Storage.
+func (e *EtcdStorage) Load(ctx context.Context) ([]byte, time.Time, error) {
+	resp, err := e.cli.Get(ctx, e.key)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, time.Time{}, errStorageNotFound
+	}
+	var s etcdSnapshot
+	if err := json.Unmarshal(resp.Kvs[0].Value, &s); err != nil {
+		return nil, time.Time{}, err
+	}
+	return s.Snapshot, s.UpdatedAt, nil
+}
+
+// Save implements Storage.
+func (e *EtcdStorage) Save(ctx context.Context, snapshot []byte, updatedAt time.Time) error {
+	b, err := json.Marshal(etcdSnapshot{Snapshot: snapshot, UpdatedAt: updatedAt})
+	if err != nil {
+		return err
+	}
+	_, err = e.cli.Put(ctx, e.key, string(b))
+	return err
+}
+
+// Watch implements Storage, forwarding etcd's native watch on e.key. The
+// returned channel receives one value per PUT event, in order; bursts of
+// PUTs are not coalesced.
+func (e *EtcdStorage) Watch(ctx context.Context) <-chan struct{} {
+	ch := make(chan struct{})
+	wch := e.cli.Watch(ctx, e.key)
+	go func() {
+		defer close(ch)
+		for resp := range wch {
+			if resp.Canceled {
+				return
+			}
+			for _, ev := range resp.Events {
+				if ev.Type != clientv3.EventTypePut {
+					continue
+				}
+				select {
+				case ch <- struct{}{}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return ch
+}
@@ -0,0 +1,64 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// FileStorage is a Storage backend that persists the threat list snapshot
+// to a single local file. It is the default backend used when
+// Config.DBPath is set, and does not support Watch since a local file has
+// no way to notify other processes of changes.
+type FileStorage struct {
+	path string
+}
+
+// NewFileStorage returns a Storage backend that persists snapshots to path.
+func NewFileStorage(path string) *FileStorage {
+	return &FileStorage{path: path}
+}
+
+// Load implements Storage.
+func (f *FileStorage) Load(ctx context.Context) ([]byte, time.Time, error) {
+	fi, err := os.Stat(f.path)
+	if err != nil {
+		return nil, time.Time{}, errStorageNotFound
+	}
+	snapshot, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return snapshot, fi.ModTime(), nil
+}
+
+// Save implements Storage. updatedAt is not recorded explicitly; the file's
+// own mtime is used as the snapshot's timestamp on the next Load.
+func (f *FileStorage) Save(ctx context.Context, snapshot []byte, updatedAt time.Time) error {
+	return ioutil.WriteFile(f.path, snapshot, 0660)
+}
+
+// Watch implements Storage. A local file cannot be watched for changes made
+// by other processes, so the returned channel only closes when ctx is done.
+func (f *FileStorage) Watch(ctx context.Context) <-chan struct{} {
+	ch := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		close(ch)
+	}()
+	return ch
+}
@@ -0,0 +1,55 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileStorageLoadMissing(t *testing.T) {
+	fs := NewFileStorage(filepath.Join(t.TempDir(), "does-not-exist.db"))
+	if _, _, err := fs.Load(context.Background()); err != errStorageNotFound {
+		t.Errorf("Load on a missing file returned err = %v, want errStorageNotFound", err)
+	}
+}
+
+func TestFileStorageSaveLoadRoundTrip(t *testing.T) {
+	fs := NewFileStorage(filepath.Join(t.TempDir(), "snapshot.db"))
+	ctx := context.Background()
+	want := []byte("threat list bytes")
+
+	if err := fs.Save(ctx, want, time.Time{}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	got, _, err := fs.Load(ctx)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("Load returned %q, want %q", got, want)
+	}
+}
+
+func TestFileStorageWatchClosesOnContextDone(t *testing.T) {
+	fs := NewFileStorage(filepath.Join(t.TempDir(), "snapshot.db"))
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := fs.Watch(ctx)
+	cancel()
+	if _, ok := <-ch; ok {
+		t.Errorf("Watch channel delivered a value; want it to only ever close once ctx is done")
+	}
+}
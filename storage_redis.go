@@ -0,0 +1,96 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// RedisStorage is a Storage backend that persists the threat list snapshot
+// to a single Redis key, and publishes to a companion Pub/Sub channel so
+// other UpdateClient replicas can react to a refresh without polling.
+type RedisStorage struct {
+	rdb     *redis.Client
+	key     string
+	channel string
+}
+
+// NewRedisStorage returns a Storage backend that persists snapshots under
+// key on rdb, and publishes update notifications on key+":updates".
+func NewRedisStorage(rdb *redis.Client, key string) *RedisStorage {
+	return &RedisStorage{rdb: rdb, key: key, channel: key + ":updates"}
+}
+
+type redisSnapshot struct {
+	Snapshot  []byte    `json:"snapshot"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Load implements Storage.
+func (r *RedisStorage) Load(ctx context.Context) ([]byte, time.Time, error) {
+	b, err := r.rdb.Get(ctx, r.key).Bytes()
+	if err == redis.Nil {
+		return nil, time.Time{}, errStorageNotFound
+	} else if err != nil {
+		return nil, time.Time{}, err
+	}
+	var s redisSnapshot
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, time.Time{}, err
+	}
+	return s.Snapshot, s.UpdatedAt, nil
+}
+
+// Save implements Storage.
+func (r *RedisStorage) Save(ctx context.Context, snapshot []byte, updatedAt time.Time) error {
+	b, err := json.Marshal(redisSnapshot{Snapshot: snapshot, UpdatedAt: updatedAt})
+	if err != nil {
+		return err
+	}
+	if err := r.rdb.Set(ctx, r.key, b, 0).Err(); err != nil {
+		return err
+	}
+	return r.rdb.Publish(ctx, r.channel, "updated").Err()
+}
+
+// Watch implements Storage by subscribing to r.channel.
+func (r *RedisStorage) Watch(ctx context.Context) <-chan struct{} {
+	ch := make(chan struct{})
+	sub := r.rdb.Subscribe(ctx, r.channel)
+	go func() {
+		defer close(ch)
+		defer sub.Close()
+		msgs := sub.Channel()
+		for {
+			select {
+			case _, ok := <-msgs:
+				if !ok {
+					return
+				}
+				select {
+				case ch <- struct{}{}:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
@@ -0,0 +1,42 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// TestRedisSnapshotRoundTrip guards the envelope Load/Save rely on to carry
+// updatedAt alongside the opaque snapshot bytes through a single Redis
+// string value; a Redis instance isn't available to exercise Load/Save
+// themselves in this environment.
+func TestRedisSnapshotRoundTrip(t *testing.T) {
+	want := redisSnapshot{
+		Snapshot:  []byte("threat list bytes"),
+		UpdatedAt: time.Unix(1700000000, 0).UTC(),
+	}
+	b, err := json.Marshal(want)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	var got redisSnapshot
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if string(got.Snapshot) != string(want.Snapshot) || !got.UpdatedAt.Equal(want.UpdatedAt) {
+		t.Errorf("round-tripped redisSnapshot = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// storageMirrorSeq generates unique file names for clients that configure
+// a non-file Storage backend without also setting DBPath.
+var storageMirrorSeq uint64
+
+// defaultStorageMirrorPath returns a process-local file path that a
+// non-file Storage backend can be mirrored through, since the database
+// only knows how to load and save a snapshot via a local file.
+func defaultStorageMirrorPath() string {
+	n := atomic.AddUint64(&storageMirrorSeq, 1)
+	return filepath.Join(os.TempDir(), fmt.Sprintf("webrisk-storage-mirror-%d.db", n))
+}
+
+// syncedSnapshot is the JSON envelope saved to Config.Storage. Storage
+// treats a snapshot as an opaque blob, so the database file and its
+// derived Bloom filter are bundled together here rather than synced
+// separately; a replica that picks this up via syncFromStorage gets both
+// in one Load, instead of racing to rebuild its own Bloom filter.
+type syncedSnapshot struct {
+	DB    []byte `json:"db"`
+	Bloom []byte `json:"bloom,omitempty"`
+}
+
+// syncFromStorage pulls the most recently saved snapshot out of
+// Config.Storage, if any, and materializes its database file (and Bloom
+// filter, if one was bundled) at Config.DBPath so that wr.db.Init and
+// wr.loadBloomFilter read the shared snapshot instead of starting cold.
+// It is a no-op if Storage isn't configured or no snapshot has been saved
+// yet.
+func (wr *UpdateClient) syncFromStorage(ctx context.Context) {
+	if wr.config.Storage == nil || wr.mirrorsDBPathDirectly() {
+		return
+	}
+	raw, _, err := wr.config.Storage.Load(ctx)
+	if err != nil {
+		wr.log.Printf("no snapshot available from storage backend: %v", err)
+		return
+	}
+	var snap syncedSnapshot
+	if err := json.Unmarshal(raw, &snap); err != nil {
+		wr.log.Printf("failed to decode storage snapshot: %v", err)
+		return
+	}
+	if err := ioutil.WriteFile(wr.config.DBPath, snap.DB, 0660); err != nil {
+		wr.log.Printf("failed to materialize storage snapshot at %s: %v", wr.config.DBPath, err)
+		return
+	}
+	if len(snap.Bloom) == 0 {
+		// No filter was bundled: drop any older one left beside DBPath so
+		// it is rebuilt from the database file just written.
+		if err := os.Remove(bloomFilterPath(wr.config.DBPath)); err != nil && !os.IsNotExist(err) {
+			wr.log.Printf("failed to remove stale bloom filter at %s: %v", bloomFilterPath(wr.config.DBPath), err)
+		}
+		return
+	}
+	if err := ioutil.WriteFile(bloomFilterPath(wr.config.DBPath), snap.Bloom, 0660); err != nil {
+		wr.log.Printf("failed to materialize storage bloom filter at %s: %v", bloomFilterPath(wr.config.DBPath), err)
+	}
+}
+
+// syncToStorage reads the local database file wr.db just wrote to
+// Config.DBPath, along with the Bloom filter persisted alongside it, and
+// saves both to Config.Storage, so that other UpdateClient replicas
+// sharing the same backend can pick up the refresh through syncFromStorage
+// or their own Storage.Watch subscription without rebuilding their own
+// Bloom filter from scratch.
+func (wr *UpdateClient) syncToStorage(ctx context.Context, updatedAt time.Time) {
+	if wr.config.Storage == nil || wr.mirrorsDBPathDirectly() {
+		return
+	}
+	db, err := ioutil.ReadFile(wr.config.DBPath)
+	if err != nil {
+		wr.log.Printf("failed to read local snapshot at %s for storage sync: %v", wr.config.DBPath, err)
+		return
+	}
+	// The Bloom filter is best-effort: an older client or one that hasn't
+	// rebuilt it yet simply omits it, and a receiving replica rebuilds its
+	// own from the database file in that case.
+	bloom, _ := ioutil.ReadFile(bloomFilterPath(wr.config.DBPath))
+
+	raw, err := json.Marshal(syncedSnapshot{DB: db, Bloom: bloom})
+	if err != nil {
+		wr.log.Printf("failed to encode storage snapshot: %v", err)
+		return
+	}
+	if err := wr.config.Storage.Save(ctx, raw, updatedAt); err != nil {
+		wr.log.Printf("failed to save snapshot to storage backend: %v", err)
+	}
+}
+
+// mirrorsDBPathDirectly reports whether Config.Storage is the default
+// file backend pointed at Config.DBPath itself, in which case wr.db
+// already reads and writes that exact file and mirroring it to itself
+// through Storage.Load/Save would be redundant.
+func (wr *UpdateClient) mirrorsDBPathDirectly() bool {
+	fs, ok := wr.config.Storage.(*FileStorage)
+	return ok && fs.path == wr.config.DBPath
+}
+
+// watchStorage runs for the lifetime of wr, re-materializing Config.DBPath
+// from Config.Storage and reloading wr.db/wr.filter from it every time
+// Storage.Watch reports that another replica saved a newer snapshot. It
+// returns once wr.done is closed.
+func (wr *UpdateClient) watchStorage() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-wr.done
+		cancel()
+	}()
+
+	for range wr.config.Storage.Watch(ctx) {
+		wr.syncFromStorage(ctx)
+		wr.reloadFromDisk()
+	}
+}
+
+// reloadFromDisk re-initializes wr.db from the file at Config.DBPath and
+// refreshes wr.filter to match, the same way NewUpdateClient does on a
+// warm start. It's used after syncFromStorage materializes a peer
+// replica's snapshot, since wr.db.Init is otherwise only ever called once;
+// without this, a replica that never performs its own successful
+// wr.db.Update keeps answering lookups against its stale cold-start
+// database and Bloom filter forever, even once the file on disk is
+// current.
+func (wr *UpdateClient) reloadFromDisk() {
+	if !wr.db.Init(&wr.config, wr.log) {
+		wr.log.Printf("failed to reload database from %s after storage sync", wr.config.DBPath)
+		return
+	}
+	if !wr.loadBloomFilter() {
+		wr.rebuildBloomFilter()
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ListDelta reports how many partial hashes a single ThreatType's list
+// gained or lost as the result of an update.
+type ListDelta struct {
+	Added   int
+	Removed int
+}
+
+// UpdateEvent describes a single completed threat-list update, delivered to
+// subscribers registered through UpdateClient.Watch.
+type UpdateEvent struct {
+	// UpdatedAt is when the update completed.
+	UpdatedAt time.Time
+
+	// Duration is how long the update took.
+	Duration time.Duration
+
+	// Deltas reports, per ThreatType, how many partial hashes were added
+	// and removed by this update.
+	Deltas map[ThreatType]ListDelta
+
+	// FullResync reports whether this update populated the lists from
+	// scratch (e.g. the very first update) rather than applying an
+	// incremental diff on top of an already-synced database.
+	FullResync bool
+}
+
+// newUpdateEvent computes the UpdateEvent for an update that ran from start
+// to end, given the per-ThreatType partial hash sets observed immediately
+// before and after it.
+func newUpdateEvent(start, end time.Time, before, after map[ThreatType][]hashPrefix) UpdateEvent {
+	deltas := make(map[ThreatType]ListDelta, len(after))
+	for tt, afterHashes := range after {
+		beforeSet := make(map[hashPrefix]bool, len(before[tt]))
+		for _, h := range before[tt] {
+			beforeSet[h] = true
+		}
+		afterSet := make(map[hashPrefix]bool, len(afterHashes))
+		for _, h := range afterHashes {
+			afterSet[h] = true
+		}
+
+		var d ListDelta
+		for h := range afterSet {
+			if !beforeSet[h] {
+				d.Added++
+			}
+		}
+		for h := range beforeSet {
+			if !afterSet[h] {
+				d.Removed++
+			}
+		}
+		deltas[tt] = d
+	}
+
+	return UpdateEvent{
+		UpdatedAt:  end,
+		Duration:   end.Sub(start),
+		Deltas:     deltas,
+		FullResync: len(before) == 0,
+	}
+}
+
+// watchRegistry tracks the set of channels subscribed through Watch. The
+// zero value is ready to use.
+type watchRegistry struct {
+	mu     sync.Mutex
+	subs   map[chan UpdateEvent]bool
+	closed bool
+}
+
+// Watch returns a channel that receives an UpdateEvent every time the
+// background updater refreshes the local threat-list database. This turns
+// UpdateClient from a pure pull-on-query library into something external
+// systems can react to: re-warming a CDN blocklist, invalidating upstream
+// caches, emitting an audit event, or simply recording freshness.
+//
+// The returned channel is closed when ctx is done, or when wr.Close is
+// called. Delivery is non-blocking: if a subscriber isn't keeping up, an
+// event is dropped for it and Stats.DroppedEvents is incremented, rather
+// than stalling the updater.
+func (wr *UpdateClient) Watch(ctx context.Context) <-chan UpdateEvent {
+	ch := make(chan UpdateEvent, 1)
+
+	wr.watchers.mu.Lock()
+	if wr.watchers.closed {
+		wr.watchers.mu.Unlock()
+		close(ch)
+		return ch
+	}
+	if wr.watchers.subs == nil {
+		wr.watchers.subs = make(map[chan UpdateEvent]bool)
+	}
+	wr.watchers.subs[ch] = true
+	wr.watchers.mu.Unlock()
+
+	go func() {
+		// wr.done is also watched here, not just ctx: a caller that passes
+		// context.Background() for the process lifetime (the natural thing
+		// to do, and what the rest of this package does for long-lived
+		// work) would otherwise leak this goroutine past wr.Close, since
+		// closeWatchers closes ch directly without waking it.
+		select {
+		case <-ctx.Done():
+		case <-wr.done:
+		}
+		wr.watchers.mu.Lock()
+		if wr.watchers.subs[ch] {
+			delete(wr.watchers.subs, ch)
+			close(ch)
+		}
+		wr.watchers.mu.Unlock()
+	}()
+
+	return ch
+}
+
+// broadcastUpdate delivers ev to every active Watch subscriber, dropping it
+// for any subscriber whose channel is full.
+func (wr *UpdateClient) broadcastUpdate(ev UpdateEvent) {
+	wr.watchers.mu.Lock()
+	defer wr.watchers.mu.Unlock()
+	for ch := range wr.watchers.subs {
+		select {
+		case ch <- ev:
+		default:
+			atomic.AddInt64(&wr.stats.DroppedEvents, 1)
+		}
+	}
+}
+
+// closeWatchers closes every subscriber channel and marks the registry
+// closed so that later Watch calls return an already-closed channel. Called
+// from Close.
+func (wr *UpdateClient) closeWatchers() {
+	wr.watchers.mu.Lock()
+	defer wr.watchers.mu.Unlock()
+	wr.watchers.closed = true
+	for ch := range wr.watchers.subs {
+		close(ch)
+		delete(wr.watchers.subs, ch)
+	}
+}
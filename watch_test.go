@@ -0,0 +1,78 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewUpdateEventDeltas(t *testing.T) {
+	start := time.Unix(0, 0)
+	end := start.Add(5 * time.Second)
+
+	before := map[ThreatType][]hashPrefix{
+		ThreatTypeMalware: {hashPrefix("aaaa"), hashPrefix("bbbb")},
+	}
+	after := map[ThreatType][]hashPrefix{
+		ThreatTypeMalware: {hashPrefix("bbbb"), hashPrefix("cccc")},
+	}
+
+	ev := newUpdateEvent(start, end, before, after)
+	if ev.UpdatedAt != end {
+		t.Errorf("UpdatedAt = %v, want %v", ev.UpdatedAt, end)
+	}
+	if ev.Duration != 5*time.Second {
+		t.Errorf("Duration = %v, want 5s", ev.Duration)
+	}
+	if ev.FullResync {
+		t.Errorf("FullResync = true, want false since before is non-empty")
+	}
+	d, ok := ev.Deltas[ThreatTypeMalware]
+	if !ok {
+		t.Fatalf("Deltas missing entry for ThreatTypeMalware")
+	}
+	if d.Added != 1 || d.Removed != 1 {
+		t.Errorf("Deltas[ThreatTypeMalware] = %+v, want Added:1 Removed:1 (cccc added, aaaa removed)", d)
+	}
+}
+
+func TestNewUpdateEventFullResync(t *testing.T) {
+	start := time.Unix(0, 0)
+	end := start.Add(time.Second)
+	after := map[ThreatType][]hashPrefix{
+		ThreatTypeMalware: {hashPrefix("aaaa")},
+	}
+
+	ev := newUpdateEvent(start, end, nil, after)
+	if !ev.FullResync {
+		t.Errorf("FullResync = false, want true since before is empty")
+	}
+	if d := ev.Deltas[ThreatTypeMalware]; d.Added != 1 || d.Removed != 0 {
+		t.Errorf("Deltas[ThreatTypeMalware] = %+v, want Added:1 Removed:0", d)
+	}
+}
+
+func TestNewUpdateEventNoChange(t *testing.T) {
+	start := time.Unix(0, 0)
+	end := start
+	hashes := map[ThreatType][]hashPrefix{
+		ThreatTypeMalware: {hashPrefix("aaaa")},
+	}
+
+	ev := newUpdateEvent(start, end, hashes, hashes)
+	if d := ev.Deltas[ThreatTypeMalware]; d.Added != 0 || d.Removed != 0 {
+		t.Errorf("Deltas[ThreatTypeMalware] = %+v, want Added:0 Removed:0 for an unchanged list", d)
+	}
+}
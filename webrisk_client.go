@@ -67,10 +67,15 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
+	"golang.org/x/sync/errgroup"
+
 	pb "github.com/google/webrisk/internal/webrisk_proto"
 )
 
@@ -90,12 +95,17 @@ const (
 	// DefaultRequestTimeout is the default amount of time a single
 	// api request can take.
 	DefaultRequestTimeout = time.Minute
+
+	// DefaultMaxConcurrentLookups is the default number of SearchHashes
+	// requests LookupURLsContext will have in flight at once.
+	DefaultMaxConcurrentLookups = 8
 )
 
 // Errors specific to this package.
 var (
-	errClosed = errors.New("webrisk: handler is closed")
-	errStale  = errors.New("webrisk: threat list is stale")
+	errClosed          = errors.New("webrisk: handler is closed")
+	errStale           = errors.New("webrisk: threat list is stale")
+	errStorageNotFound = errors.New("webrisk: no snapshot saved in storage backend")
 )
 
 // ThreatType is an enumeration type for threats classes. Examples of threat
@@ -154,8 +164,28 @@ type Config struct {
 	// If empty, UpdateClient operates in a non-persistent manner.
 	// This means that blocklist results will not be cached beyond the lifetime
 	// of the UpdateClient object.
+	//
+	// DBPath is a shortcut for setting Storage to NewFileStorage(DBPath).
+	// If Storage is set explicitly to another backend, DBPath names the
+	// local file mirrored to and from it. If Storage is set to a
+	// non-file backend and DBPath is empty, a process-local temp path is
+	// chosen automatically: the database only knows how to load and save
+	// through a local file, so that file is kept mirrored to and from
+	// Storage around every update.
 	DBPath string
 
+	// Storage, if set, is used to persist and share the synced threat list
+	// snapshot instead of (or across) local files. This allows a fleet of
+	// UpdateClient instances to share one authoritative list and update
+	// lag across a cluster rather than each independently re-downloading
+	// and rehydrating it from the API: on startup the most recent snapshot
+	// is pulled from Storage before the database loads, every successful
+	// update is pushed back to Storage, and Storage.Watch is used to pick
+	// up snapshots saved by other replicas in between. If empty, it
+	// defaults to NewFileStorage(DBPath), which is a no-op when DBPath is
+	// also empty.
+	Storage Storage
+
 	// UpdatePeriod determines how often we update the internal list database.
 	// If zero value, it defaults to DefaultUpdatePeriod.
 	UpdatePeriod time.Duration
@@ -176,6 +206,11 @@ type Config struct {
 	// RequestTimeout determines the timeout value for the http client.
 	RequestTimeout time.Duration
 
+	// MaxConcurrentLookups bounds how many SearchHashes requests
+	// LookupURLsContext will have in flight at once for a single call.
+	// If zero value, it defaults to DefaultMaxConcurrentLookups.
+	MaxConcurrentLookups int
+
 	// Logger is an io.Writer that allows UpdateClient to write debug information
 	// intended for human consumption.
 	// If empty, no logs will be written.
@@ -183,6 +218,13 @@ type Config struct {
 
 	FixedCacheTTL time.Duration
 
+	// Cache, if set, is used in place of the built-in in-process cache for
+	// full-hash lookup results. This lets a fleet of UpdateClient instances
+	// behind a load balancer share one cache instead of each independently
+	// re-querying the API for the same unsafe URLs. If empty, it defaults
+	// to the built-in in-process cache.
+	Cache Cache
+
 	// compressionTypes indicates how the threat entry sets can be compressed.
 	compressionTypes []pb.CompressionType
 
@@ -205,9 +247,20 @@ func (c *Config) setDefaults() bool {
 	if c.RequestTimeout <= 0 {
 		c.RequestTimeout = DefaultRequestTimeout
 	}
+	if c.MaxConcurrentLookups <= 0 {
+		c.MaxConcurrentLookups = DefaultMaxConcurrentLookups
+	}
 	if c.compressionTypes == nil {
 		c.compressionTypes = []pb.CompressionType{pb.CompressionType_RAW, pb.CompressionType_RICE}
 	}
+	if c.Storage == nil && c.DBPath != "" {
+		c.Storage = NewFileStorage(c.DBPath)
+	}
+	if c.Storage != nil && c.DBPath == "" {
+		// The database only knows how to load/save through a local file,
+		// so a non-file Storage backend still needs one to mirror through.
+		c.DBPath = defaultStorageMirrorPath()
+	}
 
 	return true
 }
@@ -250,7 +303,16 @@ type UpdateClient struct {
 	config Config
 	api    api
 	db     database
-	c      cache
+	c      Cache
+
+	// filter holds the current *bloomFilter prefilter, swapped in whole
+	// after each successful database update. A nil value (the zero value
+	// of the atomic.Value before the first Store) means no filter has
+	// been built yet, and LookupURLsContext falls back to the database.
+	filter atomic.Value
+
+	// watchers tracks subscribers registered through Watch.
+	watchers watchRegistry
 
 	lists map[ThreatType]bool
 
@@ -267,6 +329,7 @@ type Stats struct {
 	QueriesByAPI      int64         // Number of queries satisfied by an API call
 	QueriesFail       int64         // Number of queries that could not be satisfied
 	DatabaseUpdateLag time.Duration // Duration since last *missed* update. 0 if next update is in the future.
+	DroppedEvents     int64         // Number of UpdateEvents dropped because a Watch subscriber was not keeping up
 }
 
 // NewUpdateClient creates a new UpdateClient.
@@ -301,10 +364,13 @@ func NewUpdateClient(conf Config) (*UpdateClient, error) {
 	if conf.now == nil {
 		conf.now = time.Now
 	}
+	if conf.Cache == nil {
+		conf.Cache = &cache{now: conf.now}
+	}
 	wr := &UpdateClient{
 		config: conf,
 		api:    conf.api,
-		c:      cache{now: conf.now},
+		c:      conf.Cache,
 	}
 
 	// TODO: Verify that config.ThreatLists is a subset of the list obtained
@@ -323,9 +389,16 @@ func NewUpdateClient(conf Config) (*UpdateClient, error) {
 	}
 	wr.log = log.New(w, "webrisk: ", log.Ldate|log.Ltime|log.Lshortfile)
 
+	// Pull the most recently shared snapshot out of Storage, if configured,
+	// before the database ever looks at DBPath, so a replica joining a
+	// fleet starts from the list its peers already synced instead of
+	// re-downloading it from the API.
+	wr.syncFromStorage(context.Background())
+
 	delay := time.Duration(0)
 	// If database file is provided, use that to initialize.
-	if !wr.db.Init(&wr.config, wr.log) {
+	coldStart := !wr.db.Init(&wr.config, wr.log)
+	if coldStart {
 		ctx, cancel := context.WithTimeout(context.Background(), wr.config.RequestTimeout)
 		delay, _ = wr.db.Update(ctx, wr.api)
 		cancel()
@@ -335,9 +408,32 @@ func NewUpdateClient(conf Config) (*UpdateClient, error) {
 		}
 	}
 
+	// Load the persisted Bloom filter prefilter, if any, or build a fresh
+	// one from the database we just initialized. After a cold start the
+	// database was just fetched, so a persisted filter may describe an
+	// older database and is always rebuilt instead.
+	if coldStart || !wr.loadBloomFilter() {
+		wr.rebuildBloomFilter()
+	}
+
+	// Push the freshly fetched database and Bloom filter out to Storage
+	// now that both are on disk, so a peer replica's syncFromStorage picks
+	// up a consistent pair instead of a database with no matching filter.
+	if coldStart {
+		wr.syncToStorage(context.Background(), conf.now())
+	}
+
 	// Start the background list updater.
 	wr.done = make(chan bool)
 	go wr.updater(delay)
+
+	// Keep the local mirror (and thus the database and Bloom filter) in
+	// sync with whatever a peer replica publishes to Storage between our
+	// own periodic updates.
+	if wr.config.Storage != nil && !wr.mirrorsDBPathDirectly() {
+		go wr.watchStorage()
+	}
+
 	return wr, nil
 }
 
@@ -352,6 +448,7 @@ func (wr *UpdateClient) Status() (Stats, error) {
 		QueriesByAPI:      atomic.LoadInt64(&wr.stats.QueriesByAPI),
 		QueriesFail:       atomic.LoadInt64(&wr.stats.QueriesFail),
 		DatabaseUpdateLag: wr.db.UpdateLag(),
+		DroppedEvents:     atomic.LoadInt64(&wr.stats.DroppedEvents),
 	}
 	return stats, wr.db.Status()
 }
@@ -419,6 +516,7 @@ func (wr *UpdateClient) LookupURLsContext(ctx context.Context, urls []string) (t
 	// In the request, we only ask for partial hashes for privacy reasons.
 	var reqs []*pb.SearchHashesRequest
 	ttm := make(map[pb.ThreatType]bool)
+	seenReqs := make(map[string]bool) // coalesces requests sharing a URL/threat-type set
 
 	for i, url := range urls {
 		urlhashes, err := generateHashes(url)
@@ -433,6 +531,15 @@ func (wr *UpdateClient) LookupURLsContext(ctx context.Context, urls []string) (t
 			_, alreadyRequested := hashes[fullHash]
 			hashes[fullHash] = pattern
 
+			// Consult the Bloom filter prefilter first. A miss is
+			// authoritative: the hash cannot be in the database, so we
+			// skip straight to the "No" path without touching the
+			// (comparatively expensive) prefix tables.
+			if f, ok := wr.filter.Load().(*bloomFilter); ok && !f.mayContain(fullHash) {
+				atomic.AddInt64(&wr.stats.QueriesByDatabase, 1)
+				continue
+			}
+
 			// Lookup in database according to threat list.
 			partialHash, unsureThreats := wr.db.Lookup(fullHash)
 			if len(unsureThreats) == 0 {
@@ -441,7 +548,7 @@ func (wr *UpdateClient) LookupURLsContext(ctx context.Context, urls []string) (t
 			}
 
 			// Lookup in cache according to recently seen values.
-			cachedThreats, cr := wr.c.Lookup(fullHash)
+			cachedThreats, cr := wr.c.Lookup(ctx, fullHash)
 			switch cr {
 			case positiveCacheHit:
 				// The cache remembers this full hash as a threat.
@@ -475,6 +582,14 @@ func (wr *UpdateClient) LookupURLsContext(ctx context.Context, urls []string) (t
 					tts = append(tts, pb.ThreatType(tt))
 				}
 
+				// Requests that share a URL and threat-type set ask the API
+				// the exact same question, so only the first one is sent.
+				reqKey := url + "|" + threatTypesKey(tts)
+				if seenReqs[reqKey] {
+					continue
+				}
+				seenReqs[reqKey] = true
+
 				reqs = append(reqs, &pb.SearchHashesRequest{
 					Url:         url,
 					HashPrefix:  []byte(partialHash),
@@ -484,66 +599,178 @@ func (wr *UpdateClient) LookupURLsContext(ctx context.Context, urls []string) (t
 		}
 	}
 
+	if err := wr.resolveRequests(ctx, reqs, hashes, hash2idxs, threats); err != nil {
+		return threats, err
+	}
+	return threats, nil
+}
+
+// threatTypesKey builds a stable, order-independent map key for a set of
+// threat types, used to coalesce SearchHashesRequests that are asking the
+// API the same question.
+func threatTypesKey(tts []pb.ThreatType) string {
+	sorted := append([]pb.ThreatType(nil), tts...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	parts := make([]string, len(sorted))
+	for i, tt := range sorted {
+		parts[i] = strconv.Itoa(int(tt))
+	}
+	return strings.Join(parts, ",")
+}
+
+// resolveRequests dispatches reqs to the Web Risk API through a
+// concurrency-bounded worker pool, merging responses back into threats as
+// they arrive. It cancels outstanding work and returns the first error
+// encountered, mirroring the serial behavior it replaces.
+func (wr *UpdateClient) resolveRequests(ctx context.Context, reqs []*pb.SearchHashesRequest, hashes map[hashPrefix]string, hash2idxs map[hashPrefix][]int, threats [][]URLThreat) error {
+	if len(reqs) == 0 {
+		return nil
+	}
+
+	g, ctx := errgroup.WithContext(ctx)
+	sem := make(chan struct{}, wr.config.MaxConcurrentLookups)
+	var mu sync.Mutex // guards threats, which is not safe for concurrent writes
+
 	for _, req := range reqs {
-		// Actually query the Web Risk API for exact full hash matches.
-		wr.log.Print("Calling WR API looking for: ", req.Url)
-		resp, err := wr.api.UriLookup(ctx, req.Url, req.ThreatTypes)
-		if err != nil {
-			wr.log.Printf("UriLookup failure: %v", err)
-			atomic.AddInt64(&wr.stats.QueriesFail, 1)
-			return threats, err
-		}
+		req := req
+		sem <- struct{}{}
+		g.Go(func() error {
+			defer func() { <-sem }()
+			return wr.resolveRequest(ctx, req, hashes, hash2idxs, threats, &mu)
+		})
+	}
+	return g.Wait()
+}
 
-		// Todo: build a SearchHashesResponse out of the SearhUrisResponse and SearchHashesRequest
-		shResp := new(pb.SearchHashesResponse)
-		shResp.NegativeExpireTime = resp.Threat.ExpireTime
+// resolveRequest queries the Web Risk API for a single SearchHashesRequest
+// and merges the result into threats, guarded by mu.
+func (wr *UpdateClient) resolveRequest(ctx context.Context, req *pb.SearchHashesRequest, hashes map[hashPrefix]string, hash2idxs map[hashPrefix][]int, threats [][]URLThreat, mu *sync.Mutex) error {
+	// Actually query the Web Risk API for exact full hash matches.
+	wr.log.Print("Calling WR API looking for: ", req.Url)
+	resp, err := wr.api.UriLookup(ctx, req.Url, req.ThreatTypes)
+	if err != nil {
+		wr.log.Printf("UriLookup failure: %v", err)
+		atomic.AddInt64(&wr.stats.QueriesFail, 1)
+		return err
+	}
 
-		urlhashes, _ := generateHashes(req.Url)
+	// Todo: build a SearchHashesResponse out of the SearhUrisResponse and SearchHashesRequest
+	shResp := new(pb.SearchHashesResponse)
+	shResp.NegativeExpireTime = resp.Threat.ExpireTime
 
-		for fullHash := range urlhashes {
-			shThreat := pb.SearchHashesResponse_ThreatHash{
-				ThreatTypes: resp.Threat.ThreatTypes,
-				Hash:        []byte(fullHash),
-				ExpireTime:  resp.Threat.ExpireTime,
-			}
-			shResp.Threats = append(shResp.Threats, &shThreat)
+	urlhashes, _ := generateHashes(req.Url)
+
+	for fullHash := range urlhashes {
+		shThreat := pb.SearchHashesResponse_ThreatHash{
+			ThreatTypes: resp.Threat.ThreatTypes,
+			Hash:        []byte(fullHash),
+			ExpireTime:  resp.Threat.ExpireTime,
 		}
+		shResp.Threats = append(shResp.Threats, &shThreat)
+	}
 
-		// Update the cache.
-		wr.c.Update(req, shResp, wr)
+	// Update the cache. This may be a network call (e.g. RedisCache), so it
+	// runs outside mu: mu only needs to guard the local threats/hashes state
+	// below, not a call that's already bounded by ctx's own deadline.
+	if err := wr.c.Update(ctx, req, shResp, wr.config.now()); err != nil {
+		wr.log.Printf("cache update failure: %v", err)
+	}
 
-		// Pull the information the client cares about out of the response.
-		for _, threat := range shResp.GetThreats() {
-			wr.log.Printf("Found one threat: %+v", threat)
-			fullHash := hashPrefix(threat.Hash)
-			if !fullHash.IsFull() {
-				continue
-			}
-			pattern, ok := hashes[fullHash]
-			idxs, findidx := hash2idxs[fullHash]
-			if findidx && ok {
-				for _, td := range threat.ThreatTypes {
-					if !wr.lists[ThreatType(td)] {
-						continue
-					}
-					for _, idx := range idxs {
-						threats[idx] = append(threats[idx], URLThreat{
-							Pattern:    pattern,
-							ThreatType: ThreatType(td),
-						})
-					}
+	mu.Lock()
+	defer mu.Unlock()
+
+	// Pull the information the client cares about out of the response.
+	for _, threat := range shResp.GetThreats() {
+		wr.log.Printf("Found one threat: %+v", threat)
+		fullHash := hashPrefix(threat.Hash)
+		if !fullHash.IsFull() {
+			continue
+		}
+		pattern, ok := hashes[fullHash]
+		idxs, findidx := hash2idxs[fullHash]
+		if findidx && ok {
+			for _, td := range threat.ThreatTypes {
+				if !wr.lists[ThreatType(td)] {
+					continue
+				}
+				for _, idx := range idxs {
+					threats[idx] = append(threats[idx], URLThreat{
+						Pattern:    pattern,
+						ThreatType: ThreatType(td),
+					})
 				}
 			}
 		}
-		atomic.AddInt64(&wr.stats.QueriesByAPI, 1)
 	}
-	return threats, nil
+	atomic.AddInt64(&wr.stats.QueriesByAPI, 1)
+	return nil
 }
 
 // TODO: Add other types of lookup when available.
 //	func (wr *UpdateClient) LookupBinaries(digests []string) (threats []BinaryThreat, err error)
 //	func (wr *UpdateClient) LookupAddresses(addrs []string) (threats [][]AddressThreat, err error)
 
+// bloomFilterPath returns the path used to persist the Bloom filter
+// alongside the database file at dbPath.
+func bloomFilterPath(dbPath string) string {
+	return dbPath + ".bloom"
+}
+
+// rebuildBloomFilter recomputes the Bloom filter prefilter from the
+// database's current set of partial hashes and atomically swaps it in. If
+// Config.DBPath is set, the serialized filter is also persisted so a later
+// start that loads the database from DBPath can load it instead of
+// rebuilding from scratch.
+func (wr *UpdateClient) rebuildBloomFilter() {
+	byType := wr.db.PartialHashesByType()
+	n := 0
+	lengthSet := make(map[int]bool)
+	for _, hs := range byType {
+		n += len(hs)
+		for _, h := range hs {
+			lengthSet[len(h)] = true
+		}
+	}
+	lengths := make([]int, 0, len(lengthSet))
+	for l := range lengthSet {
+		lengths = append(lengths, l)
+	}
+
+	f := newBloomFilter(n, lengths)
+	for _, hs := range byType {
+		for _, h := range hs {
+			f.add(h)
+		}
+	}
+	wr.filter.Store(f)
+
+	if wr.config.DBPath == "" {
+		return
+	}
+	if err := ioutil.WriteFile(bloomFilterPath(wr.config.DBPath), f.marshalBinary(), 0660); err != nil {
+		wr.log.Printf("failed to persist bloom filter: %v", err)
+	}
+}
+
+// loadBloomFilter attempts to load a previously persisted Bloom filter from
+// alongside Config.DBPath. It reports whether a filter was loaded.
+func (wr *UpdateClient) loadBloomFilter() bool {
+	if wr.config.DBPath == "" {
+		return false
+	}
+	buf, err := ioutil.ReadFile(bloomFilterPath(wr.config.DBPath))
+	if err != nil {
+		return false
+	}
+	f, err := unmarshalBloomFilter(buf)
+	if err != nil {
+		wr.log.Printf("failed to load bloom filter: %v", err)
+		return false
+	}
+	wr.filter.Store(f)
+	return true
+}
+
 // updater is a blocking method that periodically updates the local database.
 // This should be run as a separate goroutine and will be automatically stopped
 // when wr.Close is called.
@@ -553,10 +780,17 @@ func (wr *UpdateClient) updater(delay time.Duration) {
 		select {
 		case <-time.After(delay):
 			var ok bool
+			before := wr.db.PartialHashesByType()
+			start := wr.config.now()
 			ctx, cancel := context.WithTimeout(context.Background(), wr.config.RequestTimeout)
 			if delay, ok = wr.db.Update(ctx, wr.api); ok {
 				wr.log.Printf("background threat list updated")
-				wr.c.Purge()
+				wr.rebuildBloomFilter()
+				wr.syncToStorage(ctx, wr.config.now())
+				wr.broadcastUpdate(newUpdateEvent(start, wr.config.now(), before, wr.db.PartialHashesByType()))
+				if err := wr.c.Purge(ctx); err != nil {
+					wr.log.Printf("cache purge failure: %v", err)
+				}
 				wr.log.Printf("cache flushed")
 			}
 			cancel()
@@ -573,6 +807,7 @@ func (wr *UpdateClient) Close() error {
 	if atomic.LoadUint32(&wr.closed) == 0 {
 		atomic.StoreUint32(&wr.closed, 1)
 		close(wr.done)
+		wr.closeWatchers()
 	}
 	return nil
 }
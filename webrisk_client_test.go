@@ -0,0 +1,42 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"testing"
+
+	pb "github.com/google/webrisk/internal/webrisk_proto"
+)
+
+func TestThreatTypesKeyOrderIndependent(t *testing.T) {
+	a := threatTypesKey([]pb.ThreatType{pb.ThreatType_MALWARE, pb.ThreatType_SOCIAL_ENGINEERING})
+	b := threatTypesKey([]pb.ThreatType{pb.ThreatType_SOCIAL_ENGINEERING, pb.ThreatType_MALWARE})
+	if a != b {
+		t.Errorf("threatTypesKey is order-dependent: %q != %q", a, b)
+	}
+}
+
+func TestThreatTypesKeyDistinguishesDifferentSets(t *testing.T) {
+	a := threatTypesKey([]pb.ThreatType{pb.ThreatType_MALWARE})
+	b := threatTypesKey([]pb.ThreatType{pb.ThreatType_SOCIAL_ENGINEERING})
+	if a == b {
+		t.Errorf("threatTypesKey(%v) == threatTypesKey(%v), want distinct keys", a, b)
+	}
+}
+
+func TestThreatTypesKeyEmpty(t *testing.T) {
+	if got := threatTypesKey(nil); got != "" {
+		t.Errorf("threatTypesKey(nil) = %q, want empty string", got)
+	}
+}